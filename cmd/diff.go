@@ -16,12 +16,26 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
 
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -33,14 +47,41 @@ import (
 
 const (
 	flagDiffStrategy = "diff-strategy"
+	flagCluster      = "cluster"
 	diffShortDesc    = "Compare manifests, based on environment or location (local or remote)"
+
+	// diffStrategyNative computes the diff against the result of a
+	// server-side dry-run apply, so it reflects defaulting, pruning, and
+	// mutating webhooks the way `kubectl diff` does on v1.13+ clusters.
+	diffStrategyNative = "native"
+
+	// minServerDryRunMinor is the minor version (within Kubernetes' 1.x line)
+	// at which the apiserver is guaranteed to support `dryRun=All`.
+	minServerDryRunMinor = 13
+
+	flagRevealSecrets = "reveal-secrets"
+
+	flagOutput = "output"
+
+	flagWatch       = "watch"
+	flagInterval    = "interval"
+	flagMetricsAddr = "metrics-addr"
+
+	flagKubeconfig = "kubeconfig"
 )
 
 func init() {
 	addEnvCmdFlags(diffCmd)
 	bindJsonnetFlags(diffCmd)
 	bindClientGoFlags(diffCmd)
-	diffCmd.PersistentFlags().String(flagDiffStrategy, "subset", "Diff strategy, all or subset.")
+	diffCmd.PersistentFlags().String(flagDiffStrategy, "subset", "Diff strategy, all, subset or native. 'native' uses a server-side dry-run and falls back to 'subset' on clusters that don't support it.")
+	diffCmd.PersistentFlags().StringArray(flagCluster, nil, "Restrict the diff to this cluster context (repeatable). Only meaningful for environments that declare multiple clusters; defaults to every cluster in the environment.")
+	diffCmd.PersistentFlags().Bool(flagRevealSecrets, false, "Show plaintext Secret values in the diff instead of a redacted length/hash summary.")
+	diffCmd.PersistentFlags().StringP(flagOutput, "o", string(kubecfg.OutputFormatText), "Output format: text, json, yaml or sarif. Non-text formats emit a machine-readable report for CI consumption.")
+	diffCmd.PersistentFlags().Bool(flagWatch, false, "Run continuously, re-diffing the single <env> argument against production every time the live cluster state changes (or on --interval), instead of diffing once and exiting.")
+	diffCmd.PersistentFlags().Duration(flagInterval, 30*time.Second, "How often to re-diff on a timer in --watch mode, in addition to reacting to live changes.")
+	diffCmd.PersistentFlags().String(flagMetricsAddr, "", "Listen address for a Prometheus /metrics endpoint exposing ksonnet_drift_objects in --watch mode, e.g. ':9103'. Disabled if empty.")
+	diffCmd.PersistentFlags().StringArray(flagKubeconfig, nil, "Load one environment's client config from this kubeconfig file list instead of $KUBECONFIG (repeatable). Of the form env=path["+string(os.PathListSeparator)+"path...], ex: --kubeconfig=prod=/creds/prod.yaml. Only applies to 'remote:'/'remote:' diffs.")
 	RootCmd.AddCommand(diffCmd)
 }
 
@@ -83,11 +124,59 @@ var diffCmd = &cobra.Command{
 			return err
 		}
 
-		c, err := initDiffCmd(cmd, wd, env1, env2, componentNames, diffStrategy)
+		clusters, err := flags.GetStringArray(flagCluster)
+		if err != nil {
+			return err
+		}
+
+		revealSecrets, err := flags.GetBool(flagRevealSecrets)
 		if err != nil {
 			return err
 		}
 
+		output, err := flags.GetString(flagOutput)
+		if err != nil {
+			return err
+		}
+		if !kubecfg.ValidOutputFormat(output) {
+			return fmt.Errorf("invalid --%s %q: must be one of text, json, yaml, sarif", flagOutput, output)
+		}
+
+		watch, err := flags.GetBool(flagWatch)
+		if err != nil {
+			return err
+		}
+		if watch {
+			if env2 != nil {
+				return fmt.Errorf("'--%s' requires a single <env> argument, ex: ks diff prod --watch", flagWatch)
+			}
+			// Keep API traffic bounded in this long-running mode regardless
+			// of what --diff-strategy was requested for the single-shot path.
+			diffStrategy = "subset"
+		}
+
+		kubeconfigFlags, err := flags.GetStringArray(flagKubeconfig)
+		if err != nil {
+			return err
+		}
+		kubeconfigs, err := parseKubeconfigFlag(kubeconfigFlags)
+		if err != nil {
+			return err
+		}
+
+		c, err := initDiffCmd(cmd, wd, env1, env2, componentNames, diffStrategy, clusters, revealSecrets, kubecfg.OutputFormat(output), kubeconfigs)
+		if err != nil {
+			return err
+		}
+
+		if watch {
+			return runDiffWatch(cmd, c, *env1)
+		}
+
+		if kubecfg.OutputFormat(output) != kubecfg.OutputFormatText {
+			return runStructuredDiff(c, kubecfg.OutputFormat(output), cmd.OutOrStdout())
+		}
+
 		return c.Run(cmd.OutOrStdout())
 	},
 	Long: `
@@ -111,6 +200,37 @@ the files in the ` + "`components/`" + ` directory.
 When a component IS specified via the ` + "`-c`" + ` flag, this command only checks
 the manifest for that particular component.
 
+By default, --diff-strategy=subset compares against what ` + "`GET`" + ` returns. Pass
+--diff-strategy=native to instead diff against the result of a server-side dry-run
+apply, which also surfaces defaulting, field pruning, and mutating webhook changes.
+This requires a Kubernetes 1.13+ apiserver; on older clusters it falls back to
+'subset' with a warning.
+
+An environment that declares multiple clusters in its spec is diffed against all
+of them at once; pass --cluster (repeatable) to restrict this to a subset.
+
+Secret manifests that carry an environment-level ` + "`encryption:`" + ` stanza (sops or
+sealed-secrets) are decrypted in-process before comparison. Diffed Secret values
+are redacted to a length/hash summary by default; pass --reveal-secrets to print
+plaintext.
+
+Pass --output/-o json, yaml, or sarif to emit a machine-readable report instead
+of the default text unified diff, for consumption by CI policy gates or code
+scanning panels. The process exit code reflects whether drift was detected
+regardless of format.
+
+Pass --watch to run continuously instead of diffing once and exiting: drift
+events are streamed to stdout as newline-delimited JSON, and a Prometheus
+/metrics endpoint (--metrics-addr) exposes ksonnet_drift_objects gauges for
+alerting. --watch only supports a single <env> argument and always uses the
+subset-diff strategy to keep API traffic bounded.
+
+For ` + "`remote:`" + `/` + "`remote:`" + ` diffs against two environments whose credentials live in
+separate kubeconfigs, pass --kubeconfig=env=path[` + "`" + string(os.PathListSeparator) + "`" + `path...]
+once per environment (repeatable) instead of relying on a single merged
+$KUBECONFIG; multiple files for one environment are merged with the standard
+clientcmd precedence rules.
+
 ### Related Commands
 
 * ` + "`ks param diff` " + `— ` + paramShortDesc["diff"] + `
@@ -141,14 +261,321 @@ ks diff dev -c redis
 `,
 }
 
-func initDiffCmd(cmd *cobra.Command, wd metadata.AbsPath, envFq1, envFq2 *string, files []string, diffStrategy string) (kubecfg.DiffCmd, error) {
+// runDiffWatch switches `ks diff env --watch` into the persistent
+// drift-detection mode: it never returns on its own, only on SIGINT/SIGTERM
+// or a fatal error from the informers.
+func runDiffWatch(cmd *cobra.Command, c kubecfg.DiffCmd, env string) error {
+	if wrapped, ok := c.(*diffCmdWithReport); ok {
+		c = wrapped.DiffCmd
+	}
+
+	remote, ok := c.(*kubecfg.DiffRemoteCmd)
+	if !ok || remote.Client == nil {
+		return fmt.Errorf("'--%s' requires a single environment with one cluster; multi-cluster environments aren't supported yet", flagWatch)
+	}
+
+	interval, err := cmd.Flags().GetDuration(flagInterval)
+	if err != nil {
+		return err
+	}
+	metricsAddr, err := cmd.Flags().GetString(flagMetricsAddr)
+	if err != nil {
+		return err
+	}
+
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		close(stopCh)
+	}()
+
+	return kubecfg.WatchDiff(remote.Client, kubecfg.WatchOptions{
+		Interval:    interval,
+		MetricsAddr: metricsAddr,
+		Env:         env,
+	}, cmd.OutOrStdout(), stopCh)
+}
+
+// structuredDiffer is implemented by the wrapper initDiffCmd's callers
+// return, alongside kubecfg.DiffCmd, so --output=json|yaml|sarif can derive
+// a real DiffReport from an actual object comparison (GVK, namespace,
+// field-level changes, and genuine created/deleted detection) instead of
+// scraping the unified-diff text Run() writes for humans.
+type structuredDiffer interface {
+	DiffReport() (kubecfg.DiffReport, error)
+}
+
+// diffCmdWithReport pairs a kubecfg.DiffCmd with the raw materials needed to
+// independently compute a structured DiffReport. Embedding kubecfg.DiffCmd
+// keeps every existing caller of c.Run()/c.(*kubecfg.DiffRemoteCmd) working
+// unchanged (see runDiffWatch, which unwraps one of these before its own
+// type assertion).
+type diffCmdWithReport struct {
+	kubecfg.DiffCmd
+	report func() (kubecfg.DiffReport, error)
+}
+
+func (d *diffCmdWithReport) DiffReport() (kubecfg.DiffReport, error) {
+	return d.report()
+}
+
+// runStructuredDiff renders c's diff as format instead of the default
+// unified-diff text, so --output=json|yaml|sarif produces an actual
+// machine-readable report, derived from c's own DiffReport() rather than
+// parsed out of the text Run() would have printed for a human.
+func runStructuredDiff(c kubecfg.DiffCmd, format kubecfg.OutputFormat, w io.Writer) error {
+	differ, ok := c.(structuredDiffer)
+	if !ok {
+		return fmt.Errorf("--%s=%s is not supported for this diff", flagOutput, format)
+	}
+
+	report, err := differ.DiffReport()
+	if err != nil {
+		return err
+	}
+
+	if err := kubecfg.RenderReport(w, format, report); err != nil {
+		return err
+	}
+
+	if report.HasDrift() {
+		return kubecfg.ErrDriftDetected
+	}
+	return nil
+}
+
+// compareObjects produces a real, structured DiffReport comparing desired
+// against actual, matched by GroupVersionKind/namespace/name: present in
+// both is reported as updated (with the changed field paths) or unchanged;
+// present only in desired is created; present only in actual is deleted.
+// Unless revealSecrets is set, both sides of every Secret are run through
+// kubecfg.RedactSecret before comparing, the same as the text renderer: a
+// Secret's plaintext must never reach a FieldChange, but redacting only one
+// side instead of both would make every Secret compare as "changed"
+// regardless of real drift, since the redacted summary is deterministic
+// and only differs when the underlying value does.
+func compareObjects(desired, actual []*unstructured.Unstructured, revealSecrets bool) kubecfg.DiffReport {
+	desiredByKey := indexByKey(desired, revealSecrets)
+	actualByKey := indexByKey(actual, revealSecrets)
+
+	var report kubecfg.DiffReport
+	for key, d := range desiredByKey {
+		rd := kubecfg.ResourceDiff{
+			GroupVersionKind: d.GroupVersionKind().String(),
+			Namespace:        d.GetNamespace(),
+			Name:             d.GetName(),
+		}
+		if a, ok := actualByKey[key]; ok {
+			changes := fieldChanges(normalizeForDiff(d.Object), normalizeForDiff(a.Object), "")
+			if len(changes) == 0 {
+				rd.Action = kubecfg.DiffActionUnchanged
+			} else {
+				rd.Action = kubecfg.DiffActionUpdated
+				rd.FieldChanges = changes
+			}
+		} else {
+			rd.Action = kubecfg.DiffActionCreated
+		}
+		report = append(report, rd)
+	}
+	for key, a := range actualByKey {
+		if _, ok := desiredByKey[key]; ok {
+			continue
+		}
+		report = append(report, kubecfg.ResourceDiff{
+			GroupVersionKind: a.GroupVersionKind().String(),
+			Namespace:        a.GetNamespace(),
+			Name:             a.GetName(),
+			Action:           kubecfg.DiffActionDeleted,
+		})
+	}
+
+	sortReport(report)
+	return report
+}
+
+// sortReport orders a DiffReport by namespace/name/GVK and each entry's
+// FieldChanges by path, so two structured-diff runs against unchanged
+// state produce byte-identical output instead of differing by Go's
+// randomized map iteration order.
+func sortReport(report kubecfg.DiffReport) {
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Namespace != report[j].Namespace {
+			return report[i].Namespace < report[j].Namespace
+		}
+		if report[i].Name != report[j].Name {
+			return report[i].Name < report[j].Name
+		}
+		return report[i].GroupVersionKind < report[j].GroupVersionKind
+	})
+	for i := range report {
+		sort.Slice(report[i].FieldChanges, func(a, b int) bool {
+			return report[i].FieldChanges[a].Path < report[i].FieldChanges[b].Path
+		})
+	}
+}
+
+func indexByKey(objs []*unstructured.Unstructured, revealSecrets bool) map[string]*unstructured.Unstructured {
+	out := make(map[string]*unstructured.Unstructured, len(objs))
+	for _, obj := range objs {
+		if !revealSecrets {
+			obj = kubecfg.RedactSecret(obj)
+		}
+		key := obj.GroupVersionKind().String() + "/" + obj.GetNamespace() + "/" + obj.GetName()
+		out[key] = obj
+	}
+	return out
+}
+
+// normalizeForDiff strips fields the apiserver (or kubectl apply) manages
+// that shouldn't factor into a diff: status, managedFields, the identity/
+// bookkeeping fields every live object carries, and the
+// last-applied-configuration annotation.
+func normalizeForDiff(obj map[string]interface{}) map[string]interface{} {
+	out := runtime.DeepCopyJSON(obj)
+	unstructured.RemoveNestedField(out, "status")
+	unstructured.RemoveNestedField(out, "metadata", "managedFields")
+	unstructured.RemoveNestedField(out, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(out, "metadata", "uid")
+	unstructured.RemoveNestedField(out, "metadata", "generation")
+	unstructured.RemoveNestedField(out, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(out, "metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration")
+	return out
+}
+
+// fieldChanges walks desired and actual in lockstep and returns one
+// FieldChange per JSON-path leaf whose value differs (recursing into
+// nested objects), so structured diff output shows exactly what changed
+// rather than only that something did.
+func fieldChanges(desired, actual map[string]interface{}, prefix string) []kubecfg.FieldChange {
+	keys := make(map[string]bool, len(desired)+len(actual))
+	for k := range desired {
+		keys[k] = true
+	}
+	for k := range actual {
+		keys[k] = true
+	}
+
+	var changes []kubecfg.FieldChange
+	for k := range keys {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		dv, dok := desired[k]
+		av, aok := actual[k]
+		switch {
+		case dok && aok:
+			dm, dIsMap := dv.(map[string]interface{})
+			am, aIsMap := av.(map[string]interface{})
+			if dIsMap && aIsMap {
+				changes = append(changes, fieldChanges(dm, am, path)...)
+				continue
+			}
+			if !reflect.DeepEqual(dv, av) {
+				changes = append(changes, kubecfg.FieldChange{Path: path, Before: av, After: dv})
+			}
+		case dok && !aok:
+			changes = append(changes, kubecfg.FieldChange{Path: path, After: dv})
+		case !dok && aok:
+			changes = append(changes, kubecfg.FieldChange{Path: path, Before: av})
+		}
+	}
+	return changes
+}
+
+// fetchLiveObjects GETs the live cluster state of each of objs via the
+// apiserver's REST API directly (the same discovery-resolved path
+// dryRunApply uses), skipping rather than erroring on any that don't exist
+// yet, so a structured diff can report them as DiffActionCreated.
+func fetchLiveObjects(restConfig *rest.Config, disco discovery.DiscoveryInterface, namespace string, objs []*unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	httpClient, err := rest.HTTPClientFor(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var live []*unstructured.Unstructured
+	for _, obj := range objs {
+		resource, namespaced, err := resourceNameFor(disco, obj.GroupVersionKind())
+		if err != nil {
+			return nil, err
+		}
+
+		ns := obj.GetNamespace()
+		if ns == "" {
+			ns = namespace
+		}
+		path := restPathFor(obj.GroupVersionKind().GroupVersion(), resource, ns, namespaced) + "/" + obj.GetName()
+
+		resp, err := httpClient.Get(strings.TrimRight(restConfig.Host, "/") + path)
+		if err != nil {
+			return nil, err
+		}
+		body, readErr := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			continue
+		case resp.StatusCode != http.StatusOK:
+			return nil, fmt.Errorf("fetching live %s/%s: %s: %s", obj.GetNamespace(), obj.GetName(), resp.Status, body)
+		}
+
+		liveObj := &unstructured.Unstructured{}
+		if err := liveObj.UnmarshalJSON(body); err != nil {
+			return nil, fmt.Errorf("decoding live %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+		}
+		live = append(live, liveObj)
+	}
+	return live, nil
+}
+
+// parseKubeconfigFlag splits --kubeconfig=env=path[:path...] values (":" is
+// os.PathListSeparator) into a per-environment file list, so two
+// `remote:` environments that keep credentials in separate kubeconfigs can
+// each be pointed at their own file(s) without requiring the user to
+// pre-merge them.
+func parseKubeconfigFlag(values []string) (map[string][]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	kubeconfigs := make(map[string][]string, len(values))
+	for _, v := range values {
+		env, paths := splitOnce(v, "=")
+		if paths == "" {
+			return nil, fmt.Errorf("invalid --%s %q: must be of the form env=path[%cpath...]", flagKubeconfig, v, os.PathListSeparator)
+		}
+		kubeconfigs[env] = filepath.SplitList(paths)
+	}
+	return kubeconfigs, nil
+}
+
+func splitOnce(s, sep string) (before, after string) {
+	i := strings.Index(s, sep)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i+len(sep):]
+}
+
+func initDiffCmd(cmd *cobra.Command, wd metadata.AbsPath, envFq1, envFq2 *string, files []string, diffStrategy string, clusters []string, revealSecrets bool, output kubecfg.OutputFormat, kubeconfigs map[string][]string) (kubecfg.DiffCmd, error) {
 	const (
 		remote = "remote"
 		local  = "local"
 	)
 
 	if envFq2 == nil {
-		return initDiffSingleEnv(*envFq1, diffStrategy, files, cmd, wd)
+		return initDiffSingleEnv(*envFq1, diffStrategy, files, clusters, revealSecrets, output, cmd, wd)
+	}
+
+	if len(clusters) > 0 {
+		return nil, fmt.Errorf("'--%s' is not currently supported for multiple environments", flagCluster)
 	}
 
 	// expect envs to be of the format local:myenv or remote:myenv
@@ -169,11 +596,11 @@ func initDiffCmd(cmd *cobra.Command, wd metadata.AbsPath, envFq1, envFq2 *string
 	}
 
 	if env1[0] == local && env2[0] == local {
-		return initDiffLocalCmd(env1[1], env2[1], diffStrategy, cmd, manager)
+		return initDiffLocalCmd(env1[1], env2[1], diffStrategy, revealSecrets, output, cmd, manager)
 	}
 
 	if env1[0] == remote && env2[0] == remote {
-		return initDiffRemotesCmd(env1[1], env2[1], diffStrategy, cmd, manager)
+		return initDiffRemotesCmd(env1[1], env2[1], diffStrategy, revealSecrets, output, kubeconfigs, cmd, manager)
 	}
 
 	localEnv := env1[1]
@@ -182,42 +609,191 @@ func initDiffCmd(cmd *cobra.Command, wd metadata.AbsPath, envFq1, envFq2 *string
 		localEnv = env2[1]
 		remoteEnv = env1[1]
 	}
-	return initDiffRemoteCmd(localEnv, remoteEnv, diffStrategy, cmd, manager)
+	return initDiffRemoteCmd(localEnv, remoteEnv, diffStrategy, revealSecrets, output, cmd, manager)
 }
 
-// initDiffSingleEnv sets up configurations for diffing using one environment
-func initDiffSingleEnv(env, diffStrategy string, files []string, cmd *cobra.Command, wd metadata.AbsPath) (kubecfg.DiffCmd, error) {
-	c := kubecfg.DiffRemoteCmd{}
-	c.DiffStrategy = diffStrategy
-	c.Client = &kubecfg.Client{}
-	var err error
-
+// initDiffSingleEnv sets up configurations for diffing using one environment.
+// An environment that declares multiple clusters in its spec fans out into
+// one kubecfg.DiffRemoteCmd per cluster, restricted to --cluster when given,
+// wrapped in a clusterFanOutCmd so a single `ks diff prod` renders drift
+// across every cluster in the environment as one report.
+func initDiffSingleEnv(env, diffStrategy string, files []string, clusters []string, revealSecrets bool, output kubecfg.OutputFormat, cmd *cobra.Command, wd metadata.AbsPath) (kubecfg.DiffCmd, error) {
 	if strings.HasPrefix(env, "remote:") || strings.HasPrefix(env, "local:") {
 		return nil, fmt.Errorf("single <env> argument with prefix 'local:' or 'remote:' not allowed")
 	}
 
-	c.Client.APIObjects, err = expandEnvCmdObjs(cmd, env, files, wd)
+	manager, err := metadata.Find(wd)
+	if err != nil {
+		return nil, err
+	}
+
+	apiObjects, err := expandEnvCmdObjs(cmd, env, files, wd)
 	if err != nil {
 		return nil, err
 	}
 
-	c.Client.ClientPool, c.Client.Discovery, err = restClientPool(cmd, &env)
+	apiObjects, err = decryptSecrets(manager, env, apiObjects)
 	if err != nil {
 		return nil, err
 	}
 
-	c.Client.Namespace, err = namespace()
+	targets, err := environmentClusters(wd, env, clusters)
 	if err != nil {
 		return nil, err
 	}
 
-	return &c, nil
+	var perCluster []*kubecfg.DiffRemoteCmd
+	var reporters []func() (kubecfg.DiffReport, error)
+	for _, target := range targets {
+		client := &kubecfg.Client{Name: target.Context, APIObjects: apiObjects}
+
+		var contextNamespace string
+		client.ClientPool, client.Discovery, contextNamespace, err = setupClientConfig(&env, target.Context, nil, cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		client.Namespace = target.Namespace
+		if client.Namespace == "" {
+			client.Namespace = contextNamespace
+		}
+
+		cc := &kubecfg.DiffRemoteCmd{}
+		cc.DiffStrategy = resolveDiffStrategy(diffStrategy, client.Discovery)
+		cc.RevealSecrets = revealSecrets
+		cc.OutputFormat = output
+		cc.Client = client
+
+		clusterConfig, _ := kubeconfigClientConfig(target.Context, nil)
+		restConfig, err := clusterConfig.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("building REST config for cluster %q: %v", target.Context, err)
+		}
+
+		if cc.DiffStrategy == diffStrategyNative {
+			client.APIObjects, err = dryRunApplyAll(restConfig, client.Discovery, client.Namespace, client.APIObjects)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		perCluster = append(perCluster, cc)
+
+		clusterName := target.Context
+		reporters = append(reporters, func() (kubecfg.DiffReport, error) {
+			live, err := fetchLiveObjects(restConfig, client.Discovery, client.Namespace, client.APIObjects)
+			if err != nil {
+				return nil, fmt.Errorf("cluster %s: %v", clusterName, err)
+			}
+			report := compareObjects(client.APIObjects, live, revealSecrets)
+			for i := range report {
+				report[i].Component = clusterName
+			}
+			return report, nil
+		})
+	}
+
+	if len(perCluster) == 1 {
+		return &diffCmdWithReport{DiffCmd: perCluster[0], report: reporters[0]}, nil
+	}
+
+	return &diffCmdWithReport{
+		DiffCmd: &clusterFanOutCmd{clusters: perCluster},
+		report: func() (kubecfg.DiffReport, error) {
+			var all kubecfg.DiffReport
+			for _, r := range reporters {
+				rep, err := r()
+				if err != nil {
+					return nil, err
+				}
+				all = append(all, rep...)
+			}
+			return all, nil
+		},
+	}, nil
+}
+
+// clusterFanOutCmd renders a single environment's multi-cluster diff as one
+// report: it runs one kubecfg.DiffRemoteCmd per cluster and writes each
+// one's output under a per-cluster heading, so `ks diff prod` against a
+// multi-cluster environment shows drift across every cluster instead of
+// being limited to whichever single cluster a bare DiffRemoteCmd could hold.
+type clusterFanOutCmd struct {
+	clusters []*kubecfg.DiffRemoteCmd
+}
+
+// Run implements kubecfg.DiffCmd. It runs every cluster even after one
+// fails, so a problem talking to one cluster doesn't hide drift (or other
+// failures) on the rest; any per-cluster errors are combined into one
+// returned error so the process exit code still reflects them.
+func (c *clusterFanOutCmd) Run(w io.Writer) error {
+	var failures []string
+
+	for _, cluster := range c.clusters {
+		fmt.Fprintf(w, "=== cluster: %s ===\n", cluster.Client.Name)
+		if err := cluster.Run(w); err != nil {
+			failures = append(failures, fmt.Sprintf("cluster %s: %v", cluster.Client.Name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// clusterTarget identifies one cluster within a (possibly multi-cluster)
+// environment: a kubeconfig context to talk to and the namespace to diff in.
+type clusterTarget struct {
+	Context   string
+	Namespace string
+}
+
+// environmentClusters resolves the set of clusters an environment spans,
+// restricted to `only` when non-empty. Environments that don't declare a
+// `clusters` stanza resolve to a single target with an empty context, which
+// setupClientConfig treats as "use the current kubeconfig context".
+func environmentClusters(wd metadata.AbsPath, env string, only []string) ([]clusterTarget, error) {
+	manager, err := metadata.Find(wd)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := manager.Environment(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(spec.Clusters) == 0 {
+		return []clusterTarget{{}}, nil
+	}
+
+	allow := make(map[string]bool, len(only))
+	for _, name := range only {
+		allow[name] = true
+	}
+
+	var targets []clusterTarget
+	for _, cl := range spec.Clusters {
+		if len(allow) > 0 && !allow[cl.Context] {
+			continue
+		}
+		targets = append(targets, clusterTarget{Context: cl.Context, Namespace: cl.Namespace})
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("--%s matched no clusters in environment %q", flagCluster, env)
+	}
+
+	return targets, nil
 }
 
 // initDiffLocalCmd sets up configurations for diffing between two sets of expanded Kubernetes objects locally
-func initDiffLocalCmd(env1, env2, diffStrategy string, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
+func initDiffLocalCmd(env1, env2, diffStrategy string, revealSecrets bool, output kubecfg.OutputFormat, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
 	c := kubecfg.DiffLocalCmd{}
 	c.DiffStrategy = diffStrategy
+	c.RevealSecrets = revealSecrets
+	c.OutputFormat = output
 	var err error
 
 	c.Env1 = &kubecfg.LocalEnv{}
@@ -234,13 +810,20 @@ func initDiffLocalCmd(env1, env2, diffStrategy string, cmd *cobra.Command, m met
 		return nil, err
 	}
 
-	return &c, nil
+	return &diffCmdWithReport{
+		DiffCmd: &c,
+		report: func() (kubecfg.DiffReport, error) {
+			return compareObjects(c.Env1.APIObjects, c.Env2.APIObjects, c.RevealSecrets), nil
+		},
+	}, nil
 }
 
 // initDiffRemotesCmd sets up configurations for diffing between objects on two remote clusters
-func initDiffRemotesCmd(env1, env2, diffStrategy string, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
+func initDiffRemotesCmd(env1, env2, diffStrategy string, revealSecrets bool, output kubecfg.OutputFormat, kubeconfigs map[string][]string, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
 	c := kubecfg.DiffRemotesCmd{}
 	c.DiffStrategy = diffStrategy
+	c.RevealSecrets = revealSecrets
+	c.OutputFormat = output
 
 	c.ClientA = &kubecfg.Client{}
 	c.ClientB = &kubecfg.Client{}
@@ -258,22 +841,29 @@ func initDiffRemotesCmd(env1, env2, diffStrategy string, cmd *cobra.Command, m m
 		return nil, err
 	}
 
-	c.ClientA.ClientPool, c.ClientA.Discovery, c.ClientA.Namespace, err = setupClientConfig(&c.ClientA.Name, cmd)
+	c.ClientA.ClientPool, c.ClientA.Discovery, c.ClientA.Namespace, err = setupClientConfig(&c.ClientA.Name, "", kubeconfigs[env1], cmd)
 	if err != nil {
 		return nil, err
 	}
-	c.ClientB.ClientPool, c.ClientB.Discovery, c.ClientB.Namespace, err = setupClientConfig(&c.ClientB.Name, cmd)
+	c.ClientB.ClientPool, c.ClientB.Discovery, c.ClientB.Namespace, err = setupClientConfig(&c.ClientB.Name, "", kubeconfigs[env2], cmd)
 	if err != nil {
 		return nil, err
 	}
 
-	return &c, nil
+	return &diffCmdWithReport{
+		DiffCmd: &c,
+		report: func() (kubecfg.DiffReport, error) {
+			return compareObjects(c.ClientA.APIObjects, c.ClientB.APIObjects, c.RevealSecrets), nil
+		},
+	}, nil
 }
 
 // initDiffRemoteCmd sets up configurations for diffing between local objects and objects on a remote cluster
-func initDiffRemoteCmd(localEnv, remoteEnv, diffStrategy string, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
+func initDiffRemoteCmd(localEnv, remoteEnv, diffStrategy string, revealSecrets bool, output kubecfg.OutputFormat, cmd *cobra.Command, m metadata.Manager) (kubecfg.DiffCmd, error) {
 	c := kubecfg.DiffRemoteCmd{}
 	c.DiffStrategy = diffStrategy
+	c.RevealSecrets = revealSecrets
+	c.OutputFormat = output
 	c.Client = &kubecfg.Client{}
 
 	var err error
@@ -282,19 +872,249 @@ func initDiffRemoteCmd(localEnv, remoteEnv, diffStrategy string, cmd *cobra.Comm
 		return nil, err
 	}
 
-	c.Client.ClientPool, c.Client.Discovery, c.Client.Namespace, err = setupClientConfig(&remoteEnv, cmd)
+	c.Client.ClientPool, c.Client.Discovery, c.Client.Namespace, err = setupClientConfig(&remoteEnv, "", nil, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	c.DiffStrategy = resolveDiffStrategy(c.DiffStrategy, c.Client.Discovery)
+
+	restConfig, err := clientConfigFor(remoteEnv).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("building REST config for environment %q: %v", remoteEnv, err)
+	}
+
+	if c.DiffStrategy == diffStrategyNative {
+		c.Client.APIObjects, err = dryRunApplyAll(restConfig, c.Client.Discovery, c.Client.Namespace, c.Client.APIObjects)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &diffCmdWithReport{
+		DiffCmd: &c,
+		report: func() (kubecfg.DiffReport, error) {
+			live, err := fetchLiveObjects(restConfig, c.Client.Discovery, c.Client.Namespace, c.Client.APIObjects)
+			if err != nil {
+				return nil, err
+			}
+			return compareObjects(c.Client.APIObjects, live, c.RevealSecrets), nil
+		},
+	}, nil
+}
+
+// resolveDiffStrategy downgrades a requested "native" strategy to "subset"
+// when the target apiserver can't be confirmed to support dryRun=All,
+// printing a warning so the fallback isn't silent.
+func resolveDiffStrategy(diffStrategy string, disco discovery.DiscoveryInterface) string {
+	if diffStrategy != diffStrategyNative {
+		return diffStrategy
+	}
+
+	if serverSupportsDryRun(disco) {
+		return diffStrategy
+	}
+
+	fmt.Fprintln(os.Stderr, "warning: --diff-strategy=native requires a server that supports dryRun=All (Kubernetes 1.13+); falling back to 'subset'")
+	return "subset"
+}
+
+// serverSupportsDryRun probes discovery for a server version new enough to
+// guarantee `?dryRun=All` support on write requests. Clusters that don't
+// report a parseable version are treated as unsupported.
+func serverSupportsDryRun(disco discovery.DiscoveryInterface) bool {
+	if disco == nil {
+		return false
+	}
+
+	version, err := disco.ServerVersion()
+	if err != nil {
+		return false
+	}
+
+	major, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(version.Major, "+"), "."))
+	if err != nil || major < 1 {
+		return false
+	}
+	minor, err := strconv.Atoi(strings.TrimRight(version.Minor, "+"))
+	if err != nil {
+		return false
+	}
+
+	return minor >= minServerDryRunMinor
+}
+
+// dryRunApplyAll replaces each of objs with the result of a server-side
+// dry-run apply, so a "native" diff compares against what the apiserver
+// would actually produce — defaulting, field pruning, and mutating
+// webhooks applied — instead of the raw local manifest. Callers only use
+// this once resolveDiffStrategy has confirmed the target server supports
+// it.
+func dryRunApplyAll(restConfig *rest.Config, disco discovery.DiscoveryInterface, namespace string, objs []*unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	out := make([]*unstructured.Unstructured, len(objs))
+	for i, obj := range objs {
+		admitted, err := dryRunApply(restConfig, disco, obj, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("server-side dry-run for %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+		}
+		out[i] = stripServerManagedFields(admitted)
+	}
+	return out, nil
+}
+
+// dryRunApply asks the apiserver to apply obj with `?dryRun=All` and
+// returns the resulting object. It tries a dry-run create first, since obj
+// may not exist live yet; if the apiserver reports a conflict because it
+// already does, it retries as a dry-run update instead.
+func dryRunApply(restConfig *rest.Config, disco discovery.DiscoveryInterface, obj *unstructured.Unstructured, defaultNamespace string) (*unstructured.Unstructured, error) {
+	resource, namespaced, err := resourceNameFor(disco, obj.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	httpClient, err := rest.HTTPClientFor(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := obj.MarshalJSON()
 	if err != nil {
 		return nil, err
 	}
 
-	return &c, nil
+	basePath := restPathFor(obj.GroupVersionKind().GroupVersion(), resource, namespace, namespaced)
+
+	created, err := dryRunRequest(httpClient, restConfig.Host, http.MethodPost, basePath+"?dryRun=All", body)
+	if err == nil {
+		return created, nil
+	}
+	if !isConflictErr(err) {
+		return nil, err
+	}
+
+	return dryRunRequest(httpClient, restConfig.Host, http.MethodPut, basePath+"/"+obj.GetName()+"?dryRun=All", body)
 }
 
-func setupClientConfig(env *string, cmd *cobra.Command) (dynamic.ClientPool, discovery.DiscoveryInterface, string, error) {
+// resourceNameFor resolves obj's plural resource name and whether it's
+// namespaced, from disco's server resource list for its GroupVersion.
+func resourceNameFor(disco discovery.DiscoveryInterface, gvk schema.GroupVersionKind) (resource string, namespaced bool, err error) {
+	list, err := disco.ServerResourcesForGroupVersion(gvk.GroupVersion().String())
+	if err != nil {
+		return "", false, fmt.Errorf("discovering resources for %s: %v", gvk.GroupVersion(), err)
+	}
+	for _, r := range list.APIResources {
+		if r.Kind == gvk.Kind && !strings.Contains(r.Name, "/") {
+			return r.Name, r.Namespaced, nil
+		}
+	}
+	return "", false, fmt.Errorf("no resource found for kind %q in %s", gvk.Kind, gvk.GroupVersion())
+}
+
+// restPathFor builds the apiserver REST path for a resource, handling the
+// core group's unprefixed "/api/v1" form separately from named groups'
+// "/apis/<group>/<version>" form.
+func restPathFor(gv schema.GroupVersion, resource, namespace string, namespaced bool) string {
+	var base string
+	if gv.Group == "" {
+		base = "/api/" + gv.Version
+	} else {
+		base = "/apis/" + gv.Group + "/" + gv.Version
+	}
+	if namespaced && namespace != "" {
+		base += "/namespaces/" + namespace
+	}
+	return base + "/" + resource
+}
+
+// conflictError marks a dryRunRequest failure as a 409, so dryRunApply
+// knows to retry as an update rather than surface a create failure.
+type conflictError struct{}
+
+func (*conflictError) Error() string { return "object already exists" }
+
+func isConflictErr(err error) bool {
+	_, ok := err.(*conflictError)
+	return ok
+}
+
+func dryRunRequest(httpClient *http.Client, host, method, path string, body []byte) (*unstructured.Unstructured, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(host, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusConflict {
+		return nil, &conflictError{}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("apiserver returned %s: %s", resp.Status, respBody)
+	}
+
+	out := &unstructured.Unstructured{}
+	if err := out.UnmarshalJSON(respBody); err != nil {
+		return nil, fmt.Errorf("decoding dry-run response: %v", err)
+	}
+	return out, nil
+}
+
+// stripServerManagedFields removes fields the apiserver populates that
+// shouldn't factor into a diff against the local manifest: status,
+// managedFields, and the last-applied-configuration annotation `kubectl
+// apply` writes.
+func stripServerManagedFields(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	out := obj.DeepCopy()
+	unstructured.RemoveNestedField(out.Object, "status")
+	unstructured.RemoveNestedField(out.Object, "metadata", "managedFields")
+	unstructured.RemoveNestedField(out.Object, "metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration")
+	return out
+}
+
+// kubeconfigClientConfig builds the clientcmd.ClientConfig and overrides used
+// to resolve a REST config for one cluster context, factored out of
+// setupClientConfig so a regression test can exercise the exact
+// loading-rules/overrides construction the command is wired to instead of
+// reimplementing an equivalent (and possibly diverging) version of it.
+func kubeconfigClientConfig(contextName string, kubeconfigPaths []string) (clientcmd.ClientConfig, *clientcmd.ConfigOverrides) {
 	overrides := &clientcmd.ConfigOverrides{}
+	if contextName != "" {
+		overrides.CurrentContext = contextName
+	}
 	loadingRules := *clientcmd.NewDefaultClientConfigLoadingRules()
 	loadingRules.DefaultClientConfig = &clientcmd.DefaultClientConfig
-	config := clientcmd.NewInteractiveDeferredLoadingClientConfig(&loadingRules, overrides, os.Stdin)
+	if len(kubeconfigPaths) > 0 {
+		loadingRules.Precedence = kubeconfigPaths
+	}
+	return clientcmd.NewInteractiveDeferredLoadingClientConfig(&loadingRules, overrides, os.Stdin), overrides
+}
+
+// setupClientConfig builds a client for the given environment. When
+// contextName is non-empty it is used explicitly, so a single invocation can
+// build configs for several clusters instead of always reading whatever the
+// current-context happens to be in $KUBECONFIG. When kubeconfigPaths is
+// non-empty, the client loads only from that file list
+// instead of whatever $KUBECONFIG resolves to, merging multiple files with
+// the standard clientcmd precedence rules — this is what lets two
+// `remote:` environments in DiffRemotesCmd point at kubeconfigs the user
+// keeps separate (e.g. prod vs. dev credentials) in one invocation.
+func setupClientConfig(env *string, contextName string, kubeconfigPaths []string, cmd *cobra.Command) (dynamic.ClientPool, discovery.DiscoveryInterface, string, error) {
+	config, overrides := kubeconfigClientConfig(contextName, kubeconfigPaths)
 
 	clientPool, discovery, err := restClient(cmd, env, config, overrides)
 	if err != nil {
@@ -309,7 +1129,13 @@ func setupClientConfig(env *string, cmd *cobra.Command) (dynamic.ClientPool, dis
 	return clientPool, discovery, namespace, nil
 }
 
-// expandEnvObjs finds and expands templates for an environment
+// expandEnvObjs finds and expands templates for an environment, decrypting
+// any Secret manifests along the way so every comparison runs against
+// plaintext. It deliberately does not redact: redaction is a render-time
+// concern keyed off RevealSecrets on the Cmd doing the comparing (Run
+// redacts whichever side it prints, after the real plaintext comparison
+// has already happened), not something to bake into the objects a
+// comparison is run against.
 func expandEnvObjs(cmd *cobra.Command, env string, manager metadata.Manager) ([]*unstructured.Unstructured, error) {
 	expander, err := newExpander(cmd)
 	if err != nil {
@@ -333,5 +1159,70 @@ func expandEnvObjs(cmd *cobra.Command, env string, manager metadata.Manager) ([]
 
 	envFiles := []string{string(envComponentPath)}
 
-	return expander.Expand(envFiles)
+	objs, err := expander.Expand(envFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptSecrets(manager, env, objs)
+}
+
+// decryptSecrets resolves the decryptor named by an environment's
+// `encryption:` stanza, if any, and runs every Secret manifest through it so
+// comparisons happen against plaintext rather than whatever ciphertext the
+// jsonnet template holds.
+func decryptSecrets(manager metadata.Manager, env string, objs []*unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	decryptor, err := resolveSecretDecryptor(manager, env)
+	if err != nil {
+		return nil, err
+	}
+	if decryptor == nil {
+		return objs, nil
+	}
+
+	decrypted := make([]*unstructured.Unstructured, len(objs))
+	for i, obj := range objs {
+		decrypted[i], err = decryptor.Decrypt(obj)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decrypted, nil
+}
+
+// resolveSecretDecryptor builds the kubecfg.SecretDecryptor named by the
+// environment spec's `encryption.provider`, or returns nil if the
+// environment doesn't declare one.
+func resolveSecretDecryptor(manager metadata.Manager, env string) (kubecfg.SecretDecryptor, error) {
+	spec, err := manager.Environment(env)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Encryption == nil || spec.Encryption.Provider == "" {
+		return nil, nil
+	}
+
+	switch spec.Encryption.Provider {
+	case "sops":
+		return kubecfg.NewSopsDecryptor(string(manager.Root()))
+	case "sealed-secrets":
+		restConfig, err := clientConfigFor(env).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("building REST config for environment %q: %v", env, err)
+		}
+		return kubecfg.NewSealedSecretsDecryptor(restConfig)
+	default:
+		return nil, fmt.Errorf("unknown encryption provider %q for environment %q", spec.Encryption.Provider, env)
+	}
+}
+
+// clientConfigFor returns a deferred client config scoped to env's current
+// kubeconfig context, used where a *rest.Config is needed directly (e.g. by
+// the sealed-secrets decryptor) rather than the dynamic.ClientPool that
+// setupClientConfig produces for the rest of this package.
+func clientConfigFor(env string) clientcmd.ClientConfig {
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: env}
+	loadingRules := *clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.DefaultClientConfig = &clientcmd.DefaultClientConfig
+	return clientcmd.NewInteractiveDeferredLoadingClientConfig(&loadingRules, overrides, os.Stdin)
 }
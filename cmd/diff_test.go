@@ -0,0 +1,283 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/ksonnet/ksonnet/pkg/kubecfg"
+)
+
+func TestRestPathForCoreGroup(t *testing.T) {
+	got := restPathFor(schema.GroupVersion{Version: "v1"}, "configmaps", "ns1", true)
+	if want := "/api/v1/namespaces/ns1/configmaps"; got != want {
+		t.Errorf("restPathFor() = %q, want %q", got, want)
+	}
+}
+
+func TestRestPathForNamedGroup(t *testing.T) {
+	got := restPathFor(schema.GroupVersion{Group: "apps", Version: "v1"}, "deployments", "ns1", true)
+	if want := "/apis/apps/v1/namespaces/ns1/deployments"; got != want {
+		t.Errorf("restPathFor() = %q, want %q", got, want)
+	}
+}
+
+func TestRestPathForClusterScoped(t *testing.T) {
+	got := restPathFor(schema.GroupVersion{Version: "v1"}, "namespaces", "ns1", false)
+	if want := "/api/v1/namespaces"; got != want {
+		t.Errorf("restPathFor() = %q, want %q", got, want)
+	}
+}
+
+func TestParseKubeconfigFlag(t *testing.T) {
+	sep := string(os.PathListSeparator)
+
+	kubeconfigs, err := parseKubeconfigFlag([]string{
+		"a=/creds/a.yaml",
+		"b=/creds/b-1.yaml" + sep + "/creds/b-2.yaml",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, want := kubeconfigs["a"], []string{"/creds/a.yaml"}; !equalStringSlices(got, want) {
+		t.Errorf("kubeconfigs[a] = %v, want %v", got, want)
+	}
+	if got, want := kubeconfigs["b"], []string{"/creds/b-1.yaml", "/creds/b-2.yaml"}; !equalStringSlices(got, want) {
+		t.Errorf("kubeconfigs[b] = %v, want %v", got, want)
+	}
+}
+
+func TestParseKubeconfigFlagEmpty(t *testing.T) {
+	kubeconfigs, err := parseKubeconfigFlag(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if kubeconfigs != nil {
+		t.Errorf("parseKubeconfigFlag(nil) = %v, want nil", kubeconfigs)
+	}
+}
+
+func TestParseKubeconfigFlagRejectsMissingEnv(t *testing.T) {
+	if _, err := parseKubeconfigFlag([]string{"/creds/a.yaml"}); err == nil {
+		t.Errorf("expected an error for a value with no env= prefix")
+	}
+}
+
+// TestRemotesUseDistinctKubeconfigs is a regression test for
+// `ks diff remote:a remote:b --kubeconfig=a=... --kubeconfig=b=...`: it
+// verifies that two environments given distinct --kubeconfig file lists
+// resolve to two different apiserver hosts, via the same
+// kubeconfigClientConfig construction setupClientConfig uses to build each
+// kubecfg.Client in initDiffRemotesCmd.
+func TestRemotesUseDistinctKubeconfigs(t *testing.T) {
+	pathA := writeTestKubeconfig(t, "https://cluster-a.example:6443")
+	pathB := writeTestKubeconfig(t, "https://cluster-b.example:6443")
+
+	kubeconfigs, err := parseKubeconfigFlag([]string{"a=" + pathA, "b=" + pathB})
+	if err != nil {
+		t.Fatalf("parseKubeconfigFlag: %v", err)
+	}
+
+	hostA, err := restHostFor(kubeconfigs["a"])
+	if err != nil {
+		t.Fatalf("resolving client config for env a: %v", err)
+	}
+	hostB, err := restHostFor(kubeconfigs["b"])
+	if err != nil {
+		t.Fatalf("resolving client config for env b: %v", err)
+	}
+
+	if hostA == hostB {
+		t.Fatalf("expected distinct apiserver hosts, both resolved to %q", hostA)
+	}
+	if hostA != "https://cluster-a.example:6443" {
+		t.Errorf("host for env a = %q, want https://cluster-a.example:6443", hostA)
+	}
+	if hostB != "https://cluster-b.example:6443" {
+		t.Errorf("host for env b = %q, want https://cluster-b.example:6443", hostB)
+	}
+}
+
+// restHostFor resolves the apiserver host for a kubeconfig file list using
+// kubeconfigClientConfig, the exact loading-rules/overrides construction
+// setupClientConfig is wired to, so a regression there is caught here too.
+func restHostFor(kubeconfigPaths []string) (string, error) {
+	config, _ := kubeconfigClientConfig("", kubeconfigPaths)
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return "", err
+	}
+	return restConfig.Host, nil
+}
+
+func writeTestKubeconfig(t *testing.T, server string) string {
+	t.Helper()
+
+	const template = `
+apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user: {}
+`
+	f, err := ioutil.TempFile("", "kubeconfig-*.yaml")
+	if err != nil {
+		t.Fatalf("creating temp kubeconfig: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(fmt.Sprintf(template, server)); err != nil {
+		t.Fatalf("writing temp kubeconfig: %v", err)
+	}
+
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	return filepath.Clean(f.Name())
+}
+
+func testConfigMap(name, namespace string, data map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+			},
+		},
+	}
+	if data != nil {
+		obj.Object["data"] = data
+	}
+	return obj
+}
+
+func TestCompareObjectsCreated(t *testing.T) {
+	report := compareObjects([]*unstructured.Unstructured{testConfigMap("a", "ns1", nil)}, nil, true)
+	if len(report) != 1 || report[0].Action != kubecfg.DiffActionCreated {
+		t.Fatalf("compareObjects() = %+v, want a single DiffActionCreated entry", report)
+	}
+}
+
+func TestCompareObjectsDeleted(t *testing.T) {
+	report := compareObjects(nil, []*unstructured.Unstructured{testConfigMap("a", "ns1", nil)}, true)
+	if len(report) != 1 || report[0].Action != kubecfg.DiffActionDeleted {
+		t.Fatalf("compareObjects() = %+v, want a single DiffActionDeleted entry", report)
+	}
+}
+
+func TestCompareObjectsUnchanged(t *testing.T) {
+	desired := testConfigMap("a", "ns1", map[string]interface{}{"k": "v"})
+	actual := testConfigMap("a", "ns1", map[string]interface{}{"k": "v"})
+	report := compareObjects([]*unstructured.Unstructured{desired}, []*unstructured.Unstructured{actual}, true)
+	if len(report) != 1 || report[0].Action != kubecfg.DiffActionUnchanged {
+		t.Fatalf("compareObjects() = %+v, want a single DiffActionUnchanged entry", report)
+	}
+}
+
+func TestCompareObjectsUpdatedReportsFieldChanges(t *testing.T) {
+	desired := testConfigMap("a", "ns1", map[string]interface{}{"k": "new"})
+	actual := testConfigMap("a", "ns1", map[string]interface{}{"k": "old"})
+	report := compareObjects([]*unstructured.Unstructured{desired}, []*unstructured.Unstructured{actual}, true)
+	if len(report) != 1 || report[0].Action != kubecfg.DiffActionUpdated {
+		t.Fatalf("compareObjects() = %+v, want a single DiffActionUpdated entry", report)
+	}
+	if want := []kubecfg.FieldChange{{Path: "data.k", Before: "old", After: "new"}}; !equalFieldChanges(report[0].FieldChanges, want) {
+		t.Errorf("FieldChanges = %+v, want %+v", report[0].FieldChanges, want)
+	}
+}
+
+func TestCompareObjectsIgnoresStatusAndResourceVersion(t *testing.T) {
+	desired := testConfigMap("a", "ns1", map[string]interface{}{"k": "v"})
+	actual := testConfigMap("a", "ns1", map[string]interface{}{"k": "v"})
+	actual.Object["status"] = map[string]interface{}{"phase": "Active"}
+	actual.Object["metadata"].(map[string]interface{})["resourceVersion"] = "12345"
+
+	report := compareObjects([]*unstructured.Unstructured{desired}, []*unstructured.Unstructured{actual}, true)
+	if len(report) != 1 || report[0].Action != kubecfg.DiffActionUnchanged {
+		t.Fatalf("compareObjects() = %+v, want status/resourceVersion to be ignored", report)
+	}
+}
+
+// TestCompareObjectsRedactsSecretsByDefault is a regression test for
+// leaking Secret plaintext into structured diff output: compareObjects
+// must never surface an un-redacted before/after for a Secret field unless
+// revealSecrets is true, even though it detects the underlying value did
+// change.
+func TestCompareObjectsRedactsSecretsByDefault(t *testing.T) {
+	desired := testSecret("a", "ns1", map[string]interface{}{"password": "new-secret"})
+	actual := testSecret("a", "ns1", map[string]interface{}{"password": "old-secret"})
+
+	report := compareObjects([]*unstructured.Unstructured{desired}, []*unstructured.Unstructured{actual}, false)
+	if len(report) != 1 || report[0].Action != kubecfg.DiffActionUpdated {
+		t.Fatalf("compareObjects() = %+v, want a single DiffActionUpdated entry", report)
+	}
+	for _, fc := range report[0].FieldChanges {
+		for _, v := range []interface{}{fc.Before, fc.After} {
+			if s, ok := v.(string); ok && (s == "new-secret" || s == "old-secret") {
+				t.Fatalf("FieldChanges leaked Secret plaintext: %+v", report[0].FieldChanges)
+			}
+		}
+	}
+}
+
+func testSecret(name, namespace string, data map[string]interface{}) *unstructured.Unstructured {
+	obj := testConfigMap(name, namespace, nil)
+	obj.Object["kind"] = "Secret"
+	obj.Object["stringData"] = data
+	return obj
+}
+
+func equalFieldChanges(a, b []kubecfg.FieldChange) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStringSlices(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
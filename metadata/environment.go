@@ -0,0 +1,45 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package metadata
+
+// ClusterSpec identifies one Kubernetes cluster an environment spans, as
+// declared by a `clusters:` stanza in that environment's spec.json. An
+// environment with no `clusters:` stanza spans a single, implicit cluster:
+// whatever $KUBECONFIG's current context points at.
+type ClusterSpec struct {
+	// Context is the kubeconfig context to diff this cluster through.
+	Context string `json:"context"`
+	// Namespace overrides the context's default namespace, if set.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// EncryptionSpec names the decryption provider to run this environment's
+// Secret manifests through before they're compared, as declared by an
+// `encryption:` stanza in spec.json.
+type EncryptionSpec struct {
+	// Provider is one of "sops" or "sealed-secrets".
+	Provider string `json:"provider"`
+}
+
+// EnvironmentSpec is the on-disk schema of an environment's spec.json.
+type EnvironmentSpec struct {
+	// Clusters lists the clusters this environment spans. Empty means the
+	// environment is single-cluster.
+	Clusters []ClusterSpec `json:"clusters,omitempty"`
+	// Encryption names the Secret decryption provider this environment
+	// uses, if any.
+	Encryption *EncryptionSpec `json:"encryption,omitempty"`
+}
@@ -0,0 +1,231 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ghodss/yaml"
+)
+
+// ErrDriftDetected is returned when a structured-format (json/yaml/sarif)
+// diff report has drift, as DiffReport.HasDrift defines it, so CI
+// policy gates can key off the process exit code the way they already do
+// for the text renderer's non-zero diff output.
+var ErrDriftDetected = errors.New("drift detected")
+
+// DiffAction describes how a single resource differs between the two sides
+// of a diff.
+type DiffAction string
+
+// The set of actions a ResourceDiff can report.
+const (
+	DiffActionCreated   DiffAction = "created"
+	DiffActionUpdated   DiffAction = "updated"
+	DiffActionDeleted   DiffAction = "deleted"
+	DiffActionUnchanged DiffAction = "unchanged"
+)
+
+// FieldChange is a single before/after pair within a ResourceDiff, keyed by
+// its JSON path (e.g. "spec.replicas").
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// ResourceDiff reports the outcome for a single Kubernetes object.
+type ResourceDiff struct {
+	GroupVersionKind string        `json:"gvk,omitempty"`
+	Namespace        string        `json:"namespace,omitempty"`
+	Name             string        `json:"name"`
+	Component        string        `json:"component,omitempty"`
+	Action           DiffAction    `json:"action"`
+	FieldChanges     []FieldChange `json:"fieldChanges,omitempty"`
+}
+
+// DiffReport is the top-level machine-readable result of a diff run.
+type DiffReport []ResourceDiff
+
+// HasDrift reports whether the report contains any non-unchanged resource,
+// matching the exit-code semantics of the text renderer.
+func (r DiffReport) HasDrift() bool {
+	for _, rd := range r {
+		if rd.Action != DiffActionUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputFormat selects how a DiffCmd renders its result.
+type OutputFormat string
+
+// Supported OutputFormat values. OutputFormatText selects the human
+// unified-diff output and is the --output flag's default.
+const (
+	OutputFormatText  OutputFormat = "text"
+	OutputFormatJSON  OutputFormat = "json"
+	OutputFormatYAML  OutputFormat = "yaml"
+	OutputFormatSARIF OutputFormat = "sarif"
+)
+
+// ValidOutputFormat reports whether s names a supported OutputFormat.
+func ValidOutputFormat(s string) bool {
+	switch OutputFormat(s) {
+	case OutputFormatText, OutputFormatJSON, OutputFormatYAML, OutputFormatSARIF:
+		return true
+	}
+	return false
+}
+
+// RenderReport writes report to w in the given format. Callers that already
+// special-case OutputFormatText (to keep the existing unified-diff writer)
+// should not call this for that format.
+func RenderReport(w io.Writer, format OutputFormat, report DiffReport) error {
+	switch format {
+	case OutputFormatJSON:
+		return renderJSON(w, report)
+	case OutputFormatYAML:
+		return renderYAML(w, report)
+	case OutputFormatSARIF:
+		return renderSARIF(w, report)
+	default:
+		return fmt.Errorf("unsupported diff output format %q", format)
+	}
+}
+
+func renderJSON(w io.Writer, report DiffReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func renderYAML(w io.Writer, report DiffReport) error {
+	out, err := yaml.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// sarifLog is a minimal SARIF 2.1.0 log sufficient for GitHub/GitLab
+// code-scanning panels: one run, one rule per distinct action, one result
+// per changed resource. A result's location URI is the ResourceDiff's
+// Component, or its Name when Component is empty; it is not the path of
+// the component's source file.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string `json:"id"`
+	ShortDescription struct {
+		Text string `json:"text"`
+	} `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+func renderSARIF(w io.Writer, report DiffReport) error {
+	log := sarifLog{
+		Schema:  "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name: "ksonnet-diff",
+				Rules: []sarifRule{
+					sarifRuleFor(DiffActionCreated),
+					sarifRuleFor(DiffActionUpdated),
+					sarifRuleFor(DiffActionDeleted),
+				},
+			}},
+		}},
+	}
+
+	for _, rd := range report {
+		if rd.Action == DiffActionUnchanged {
+			continue
+		}
+		location := rd.Component
+		if location == "" {
+			location = rd.Name
+		}
+		log.Runs[0].Results = append(log.Runs[0].Results, sarifResult{
+			RuleID: string(rd.Action),
+			Level:  "warning",
+			Message: sarifMessage{
+				Text: fmt.Sprintf("%s %s %s/%s", rd.Action, rd.GroupVersionKind, rd.Namespace, rd.Name),
+			},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: location},
+				},
+			}},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+func sarifRuleFor(action DiffAction) sarifRule {
+	r := sarifRule{ID: string(action)}
+	r.ShortDescription.Text = fmt.Sprintf("Resource was %s", action)
+	return r
+}
@@ -0,0 +1,256 @@
+// Copyright 2017 The kubecfg authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package kubecfg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+)
+
+// secretKind is the kind of the core v1.Secret resource that
+// SecretDecryptors and diff redaction operate on.
+const secretKind = "Secret"
+
+// SecretDecryptor decrypts the data/stringData blobs of a single Secret
+// manifest in-process, before it's compared against the live cluster. Both
+// implementations below are best-effort: a Secret that a decryptor doesn't
+// recognize is returned unmodified so expandEnvObjs can keep working with
+// plaintext fixtures and already-decrypted manifests alike.
+type SecretDecryptor interface {
+	// Decrypt returns a copy of obj with any encrypted data/stringData
+	// entries replaced by their plaintext values. Each implementation
+	// handles one kind (SopsDecryptor: Secret; SealedSecretsDecryptor:
+	// SealedSecret) and returns objects of any other kind unchanged.
+	Decrypt(obj *unstructured.Unstructured) (*unstructured.Unstructured, error)
+}
+
+// SopsDecryptor decrypts Secrets encrypted with Mozilla sops, driven by the
+// `.sops.yaml` creation rules at the root of the ksonnet app.
+type SopsDecryptor struct {
+	appRoot string
+}
+
+// NewSopsDecryptor returns a SecretDecryptor backed by the `sops` binary,
+// using the `.sops.yaml` found at appRoot.
+func NewSopsDecryptor(appRoot string) (*SopsDecryptor, error) {
+	if _, err := exec.LookPath("sops"); err != nil {
+		return nil, fmt.Errorf("encryption provider \"sops\" requires the sops binary on $PATH: %v", err)
+	}
+	return &SopsDecryptor{appRoot: appRoot}, nil
+}
+
+// sopsMetadataField is the top-level key sops adds to a document it
+// encrypts, holding the wrapped data key and MAC needed to decrypt every
+// other field in that same document. Its presence is what distinguishes a
+// sops-encrypted manifest from a plaintext one.
+const sopsMetadataField = "sops"
+
+// Decrypt shells out to `sops --decrypt` on obj's entire JSON document. sops
+// ciphertext can only be decrypted together with the document's own `sops:`
+// metadata footer (the wrapped data key and MAC), so this has to operate on
+// the whole Secret manifest rather than per-value snippets: a lone
+// `ENC[...]` string copied out of `data` has nothing to decrypt it with.
+// Secrets with no `sops:` footer are assumed already-plaintext and returned
+// unchanged.
+func (d *SopsDecryptor) Decrypt(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if obj.GetKind() != secretKind {
+		return obj, nil
+	}
+	if _, found, _ := unstructured.NestedMap(obj.Object, sopsMetadataField); !found {
+		return obj, nil
+	}
+
+	body, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("marshaling %s/%s for sops: %v", obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	cmd := exec.Command("sops", "--config", filepath.Join(d.appRoot, ".sops.yaml"), "--input-type", "json", "--output-type", "json", "--decrypt", "/dev/stdin")
+	cmd.Stdin = bytes.NewReader(body)
+	plain, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sops --decrypt %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	out := &unstructured.Unstructured{}
+	if err := out.UnmarshalJSON(plain); err != nil {
+		return nil, fmt.Errorf("decoding sops output for %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+	}
+	unstructured.RemoveNestedField(out.Object, sopsMetadataField)
+	return out, nil
+}
+
+// SealedSecretsDecryptor "decrypts" bitnami-labs/sealed-secrets resources by
+// asking the cluster to unseal them server-side and substituting the result,
+// so a diff can compare against what the controller would actually produce.
+type SealedSecretsDecryptor struct {
+	restConfig *rest.Config
+}
+
+// NewSealedSecretsDecryptor returns a SecretDecryptor that applies
+// SealedSecret manifests to the cluster so the controller can reconcile
+// them, using the given REST config.
+func NewSealedSecretsDecryptor(restConfig *rest.Config) (*SealedSecretsDecryptor, error) {
+	if restConfig == nil {
+		return nil, fmt.Errorf("encryption provider \"sealed-secrets\" requires a cluster REST config")
+	}
+	return &SealedSecretsDecryptor{restConfig: restConfig}, nil
+}
+
+// sealedSecretsGroupVersion is the bitnami-labs/sealed-secrets CRD's group
+// and version.
+const sealedSecretsGroupVersion = "bitnami.com/v1alpha1"
+
+// sealedSecretsPollInterval/Timeout bound how long Decrypt waits for the
+// controller to reconcile an applied SealedSecret into its plaintext
+// Secret.
+const (
+	sealedSecretsPollInterval = 500 * time.Millisecond
+	sealedSecretsPollTimeout  = 30 * time.Second
+)
+
+// Decrypt applies obj (a SealedSecret) to the cluster and waits for the
+// sealed-secrets controller to reconcile it into the plaintext Secret of
+// the same name, then returns that Secret. The controller doesn't expose
+// an unseal-over-HTTP API by design: only it holds the private key, and it
+// only ever uses it to reconcile a SealedSecret it's been asked to manage.
+// Applying and reading the result back is the only way to recover
+// plaintext through the API, mirroring what `kubeseal` itself expects a
+// cluster operator to do. For anything other than a SealedSecret, obj is
+// returned unchanged.
+func (d *SealedSecretsDecryptor) Decrypt(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if obj.GetKind() != "SealedSecret" {
+		return obj, nil
+	}
+
+	httpClient, err := rest.HTTPClientFor(d.restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("building client for sealed-secrets controller: %v", err)
+	}
+	host := strings.TrimRight(d.restConfig.Host, "/")
+
+	if err := applySealedSecret(httpClient, host, obj); err != nil {
+		return nil, fmt.Errorf("applying %s/%s for unseal: %v", obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	secret, err := waitForUnsealedSecret(httpClient, host, obj.GetNamespace(), obj.GetName())
+	if err != nil {
+		return nil, fmt.Errorf("unsealing %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+	}
+	return secret, nil
+}
+
+// applySealedSecret PUTs obj to the cluster so the sealed-secrets
+// controller notices and reconciles it.
+func applySealedSecret(httpClient *http.Client, host string, obj *unstructured.Unstructured) error {
+	body, err := json.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/apis/%s/namespaces/%s/sealedsecrets/%s", sealedSecretsGroupVersion, obj.GetNamespace(), obj.GetName())
+	req, err := http.NewRequest(http.MethodPut, host+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("controller returned %s: %s", resp.Status, respBody)
+	}
+	return nil
+}
+
+// waitForUnsealedSecret polls for the plaintext Secret the sealed-secrets
+// controller produces when it reconciles a SealedSecret of the same
+// namespace/name, until it appears or sealedSecretsPollTimeout elapses.
+func waitForUnsealedSecret(httpClient *http.Client, host, namespace, name string) (*unstructured.Unstructured, error) {
+	path := fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", namespace, name)
+	deadline := time.Now().Add(sealedSecretsPollTimeout)
+
+	for {
+		resp, err := httpClient.Get(host + path)
+		if err != nil {
+			return nil, err
+		}
+		body, readErr := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			out := &unstructured.Unstructured{}
+			if err := out.UnmarshalJSON(body); err != nil {
+				return nil, fmt.Errorf("decoding reconciled Secret: %v", err)
+			}
+			return out, nil
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			return nil, fmt.Errorf("fetching reconciled Secret: %s: %s", resp.Status, body)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for the controller to reconcile %s/%s", sealedSecretsPollTimeout, namespace, name)
+		}
+		time.Sleep(sealedSecretsPollInterval)
+	}
+}
+
+// RedactSecret replaces a Secret's data/stringData values with a
+// length-and-hash summary so diff output never leaks plaintext unless
+// --reveal-secrets was passed. The summary is still useful for spotting
+// drift: two differently-valued Secrets redact to different summaries.
+func RedactSecret(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if obj == nil || obj.GetKind() != secretKind {
+		return obj
+	}
+
+	out := obj.DeepCopy()
+	for _, field := range []string{"data", "stringData"} {
+		values, found, err := unstructured.NestedStringMap(out.Object, field)
+		if err != nil || !found {
+			continue
+		}
+		for key, val := range values {
+			values[key] = redactedSummary(val)
+		}
+		_ = unstructured.SetNestedStringMap(out.Object, values, field)
+	}
+	return out
+}
+
+func redactedSummary(val string) string {
+	sum := sha256.Sum256([]byte(val))
+	return fmt.Sprintf("<redacted len=%d sha256=%x>", len(val), sum[:4])
+}